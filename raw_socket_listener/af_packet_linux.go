@@ -0,0 +1,106 @@
+// +build linux
+
+package rawSocket
+
+import (
+	"log"
+	"sync"
+	"syscall"
+)
+
+// Linux networking constants not exposed by the syscall package.
+const (
+	ethPAll          = 0x0003 // ETH_P_ALL, network byte order handled below
+	packetFanout     = 18     // PACKET_FANOUT
+	packetFanoutHash = 0      // PACKET_FANOUT_HASH
+)
+
+// htons converts a uint16 from host to network byte order, since syscall
+// does not expose it and AF_PACKET sockets are bound by protocol number in
+// network byte order.
+func htons(i uint16) uint16 {
+	return (i<<8)&0xff00 | i>>8
+}
+
+// readAFPacket opens FanoutSize AF_PACKET sockets, joins them to a single
+// PACKET_FANOUT_HASH group so the kernel consistently steers each 5-tuple
+// to the same socket, and runs one reader goroutine per socket feeding
+// packetsChan.
+func (t *Listener) readAFPacket() {
+	fanoutID := uint16(t.port)
+	if fanoutID == 0 {
+		fanoutID = 1
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < t.FanoutSize; i++ {
+		fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPAll)))
+		if err != nil {
+			log.Fatal("AF_PACKET socket: ", err)
+		}
+
+		if t.kernelFilter {
+			if err := attachFilterFD(fd, CompilePortFilter(t.port)); err != nil {
+				log.Println("SO_ATTACH_FILTER not supported, falling back to userspace filtering:", err)
+			}
+		}
+
+		fanoutArg := int(fanoutID) | (packetFanoutHash << 16)
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_PACKET, packetFanout, fanoutArg); err != nil {
+			log.Fatal("PACKET_FANOUT: ", err)
+		}
+
+		t.afPacketSockets = append(t.afPacketSockets, fd)
+
+		wg.Add(1)
+		go t.readAFPacketSocket(fd, &wg)
+	}
+
+	wg.Wait()
+}
+
+func (t *Listener) readAFPacketSocket(fd int, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EBADF || err == syscall.EINVAL {
+				return
+			}
+			continue
+		}
+
+		if n <= 0 {
+			continue
+		}
+
+		tcpBuf, srcAddr, dstAddr, ok := stripEthIP(buf[:n])
+		if !ok {
+			continue
+		}
+
+		if !t.isValidPacket(tcpBuf) {
+			continue
+		}
+
+		newBuf := make([]byte, len(tcpBuf))
+		copy(newBuf, tcpBuf)
+
+		teeBuf := make([]byte, n)
+		copy(teeBuf, buf[:n])
+		t.teeRaw(teeBuf)
+
+		addr := peerAddr(tcpBuf, t.port, srcAddr, dstAddr)
+		t.enqueuePacket(ParseTCPPacket(addr, newBuf))
+	}
+}
+
+func (t *Listener) closeAFPacket() {
+	for _, fd := range t.afPacketSockets {
+		syscall.Close(fd)
+	}
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package rawSocket
+
+import "log"
+
+// readAFPacket is only implemented on Linux, where AF_PACKET and
+// PACKET_FANOUT exist.
+func (t *Listener) readAFPacket() {
+	log.Fatal("CaptureModeAFPacket is only supported on Linux")
+}
+
+func (t *Listener) closeAFPacket() {
+}
@@ -0,0 +1,67 @@
+// +build linux,amd64 linux,arm64
+
+package rawSocket
+
+import (
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// PACKET_STATISTICS isn't exposed by the syscall package and, like
+// SO_ATTACH_FILTER in bpf_attach_linux.go, has to be reached via a raw
+// socket-option syscall with a struct pointer, which syscall.GetsockoptInt
+// can't express. This file is built for linux/amd64 and linux/arm64 only,
+// the same set as bpf_attach_linux.go; af_packet_stats_other.go covers
+// every other arch (linux or not).
+const packetStatistics = 6 // PACKET_STATISTICS
+
+// tpacketStats mirrors struct tpacket_stats (linux/if_packet.h), the value
+// returned by getsockopt(PACKET_STATISTICS). The kernel resets tp_drops to
+// zero on every read, which is why kernelDrops below accumulates it rather
+// than returning it directly.
+type tpacketStats struct {
+	Packets uint32
+	Drops   uint32
+}
+
+// readPacketDrops reads and resets fd's PACKET_STATISTICS counters,
+// returning how many packets the kernel has dropped for this socket since
+// the last read.
+func readPacketDrops(fd int) (uint32, error) {
+	var stats tpacketStats
+	size := uint32(unsafe.Sizeof(stats))
+
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_GETSOCKOPT,
+		uintptr(fd),
+		uintptr(syscall.SOL_PACKET),
+		uintptr(packetStatistics),
+		uintptr(unsafe.Pointer(&stats)),
+		uintptr(unsafe.Pointer(&size)),
+		0,
+	)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return stats.Drops, nil
+}
+
+// kernelDrops sums PACKET_STATISTICS across every AF_PACKET socket this
+// Listener owns and folds the result into the running total Stats()
+// reports, since the kernel only ever reports drops since the last read.
+func (t *Listener) kernelDrops() uint64 {
+	var delta uint32
+	for _, fd := range t.afPacketSockets {
+		if d, err := readPacketDrops(fd); err == nil {
+			delta += d
+		}
+	}
+
+	if delta > 0 {
+		atomic.AddInt64(&t.statPacketsDroppedKernel, int64(delta))
+	}
+
+	return uint64(atomic.LoadInt64(&t.statPacketsDroppedKernel))
+}
@@ -0,0 +1,14 @@
+// +build !linux linux,!amd64,!arm64
+
+package rawSocket
+
+import "sync/atomic"
+
+// kernelDrops is always zero here: PACKET_STATISTICS is only reachable via
+// the raw SYS_GETSOCKOPT call in af_packet_stats_linux.go, which is only
+// built for linux/amd64 and linux/arm64. CaptureModeRAW can't observe kernel drops
+// either way (see Stats.PacketsDroppedKernel), so this is also what a
+// RAW-mode Listener on any platform reports.
+func (t *Listener) kernelDrops() uint64 {
+	return uint64(atomic.LoadInt64(&t.statPacketsDroppedKernel))
+}
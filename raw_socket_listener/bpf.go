@@ -0,0 +1,115 @@
+package rawSocket
+
+// bpfInstruction mirrors the kernel's struct sock_filter (linux/filter.h),
+// i.e. one instruction of a classic BPF program as consumed by
+// SO_ATTACH_FILTER.
+type bpfInstruction struct {
+	Op uint16
+	Jt uint8
+	Jf uint8
+	K  uint32
+}
+
+// Classic BPF opcode fields, from linux/bpf_common.h. Only the subset used
+// by CompilePortFilter is defined here.
+const (
+	bpfLd  = 0x00
+	bpfLdx = 0x01
+	bpfJmp = 0x05
+	bpfRet = 0x06
+
+	bpfW = 0x00
+	bpfH = 0x08
+	bpfB = 0x10
+
+	bpfAbs = 0x20
+	bpfInd = 0x40
+	bpfMsh = 0xa0
+
+	bpfJeq  = 0x10
+	bpfJset = 0x40
+
+	bpfK = 0x00
+)
+
+func stmt(code uint16, k uint32) bpfInstruction {
+	return bpfInstruction{Op: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) bpfInstruction {
+	return bpfInstruction{Op: code, Jt: jt, Jf: jf, K: k}
+}
+
+// CompilePortFilter builds a classic BPF program equivalent to tcpdump's
+// `tcp and port <port>`, meant to be attached to a capture socket via
+// SO_ATTACH_FILTER so the kernel drops everything else before it is copied
+// into userspace.
+//
+// It assumes an Ethernet link layer (as delivered by an AF_PACKET socket):
+// IPv4/TCP packets are matched on source or destination port, with
+// fragments other than the first dropped since they don't carry a TCP
+// header. IPv6 packets are accepted on EtherType alone and left for
+// Listener.isValidPacket to filter by port in userspace, since the
+// variable-length IPv6 extension header chain makes a fixed BPF offset
+// unreliable. Everything else (ARP, etc.) is dropped.
+func CompilePortFilter(port uint16) []bpfInstruction {
+	p := uint32(port)
+
+	return []bpfInstruction{
+		// 0: A = EtherType
+		stmt(bpfLd|bpfH|bpfAbs, 12),
+		// 1: IPv4? fall through to 2, else jump to the IPv6 check at 13
+		jump(bpfJmp|bpfJeq|bpfK, 0x0800, 0, 11),
+		// 2: A = IP protocol
+		stmt(bpfLd|bpfB|bpfAbs, 23),
+		// 3: TCP? fall through to 4, else drop (12)
+		jump(bpfJmp|bpfJeq|bpfK, 6, 0, 8),
+		// 4: A = IP flags + fragment offset
+		stmt(bpfLd|bpfH|bpfAbs, 20),
+		// 5: not-first-fragment? drop (12), else fall through to 6
+		jump(bpfJmp|bpfJset|bpfK, 0x1fff, 6, 0),
+		// 6: X = IP header length (IHL * 4)
+		stmt(bpfLdx|bpfB|bpfMsh, 14),
+		// 7: A = TCP source port
+		stmt(bpfLd|bpfH|bpfInd, 14),
+		// 8: source port match? accept (11), else fall through to 9
+		jump(bpfJmp|bpfJeq|bpfK, p, 2, 0),
+		// 9: A = TCP dest port
+		stmt(bpfLd|bpfH|bpfInd, 16),
+		// 10: dest port match? accept (11), else drop (12)
+		jump(bpfJmp|bpfJeq|bpfK, p, 0, 1),
+		// 11: accept (IPv4, matched port)
+		stmt(bpfRet|bpfK, 65535),
+		// 12: drop (IPv4, wrong proto/fragment/port)
+		stmt(bpfRet|bpfK, 0),
+		// 13: IPv6? accept (14), else drop (15) -- A still holds EtherType from instruction 0
+		jump(bpfJmp|bpfJeq|bpfK, 0x86DD, 0, 1),
+		// 14: accept (IPv6, port filtering deferred to userspace)
+		stmt(bpfRet|bpfK, 65535),
+		// 15: drop (neither IPv4 nor IPv6)
+		stmt(bpfRet|bpfK, 0),
+	}
+}
+
+// CompileBarePortFilter builds a classic BPF program matching source or
+// destination port <port> on a bare TCP segment with no link or IP layer,
+// i.e. what a `ip4:tcp`/`ip6:tcp` RAW_SOCKET conn already hands to
+// Listener.isValidPacket.
+func CompileBarePortFilter(port uint16) []bpfInstruction {
+	p := uint32(port)
+
+	return []bpfInstruction{
+		// 0: A = TCP source port
+		stmt(bpfLd|bpfH|bpfAbs, 0),
+		// 1: source port match? accept (4), else fall through to 2
+		jump(bpfJmp|bpfJeq|bpfK, p, 2, 0),
+		// 2: A = TCP dest port
+		stmt(bpfLd|bpfH|bpfAbs, 2),
+		// 3: dest port match? accept (4), else drop (5)
+		jump(bpfJmp|bpfJeq|bpfK, p, 0, 1),
+		// 4: accept
+		stmt(bpfRet|bpfK, 65535),
+		// 5: drop
+		stmt(bpfRet|bpfK, 0),
+	}
+}
@@ -0,0 +1,70 @@
+// +build linux,amd64 linux,arm64
+
+package rawSocket
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// sockFprog mirrors struct sock_fprog (linux/filter.h), the argument type
+// expected by SO_ATTACH_FILTER. The explicit padding assumes an 8-byte
+// aligned pointer (amd64/arm64); 32-bit targets are not supported.
+type sockFprog struct {
+	Len    uint16
+	_      [6]byte
+	Filter *bpfInstruction
+}
+
+// attachFilterFD attaches a compiled classic BPF program to fd via
+// SO_ATTACH_FILTER.
+func attachFilterFD(fd int, filter []bpfInstruction) error {
+	prog := sockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_SETSOCKOPT,
+		uintptr(fd),
+		uintptr(syscall.SOL_SOCKET),
+		uintptr(syscall.SO_ATTACH_FILTER),
+		uintptr(unsafe.Pointer(&prog)),
+		unsafe.Sizeof(prog),
+		0,
+	)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// attachBarePortFilter attaches CompileBarePortFilter(port) to the raw fd
+// backing conn, if conn exposes one (e.g. the ip4:tcp/ip6:tcp conn used by
+// readRAWSocket).
+func attachBarePortFilter(conn net.PacketConn, port uint16) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("conn does not support SyscallConn")
+	}
+
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	filter := CompileBarePortFilter(port)
+
+	var attachErr error
+	err = rawConn.Control(func(fd uintptr) {
+		attachErr = attachFilterFD(int(fd), filter)
+	})
+	if err != nil {
+		return err
+	}
+
+	return attachErr
+}
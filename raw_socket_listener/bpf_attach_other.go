@@ -0,0 +1,22 @@
+// +build !linux linux,!amd64,!arm64
+
+package rawSocket
+
+import (
+	"errors"
+	"net"
+)
+
+// errBPFNotSupported also covers linux/386 and other 32-bit linux archs:
+// attachFilterFD's SO_ATTACH_FILTER call (bpf_attach_linux.go) needs an
+// 8-byte aligned sockFprog pointer and is restricted to linux/amd64 and
+// linux/arm64 accordingly.
+var errBPFNotSupported = errors.New("SO_ATTACH_FILTER is only supported on linux/amd64 and linux/arm64")
+
+func attachFilterFD(fd int, filter []bpfInstruction) error {
+	return errBPFNotSupported
+}
+
+func attachBarePortFilter(conn net.PacketConn, port uint16) error {
+	return errBPFNotSupported
+}
@@ -0,0 +1,138 @@
+package rawSocket
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// runBPF interprets prog against pkt using just the instruction subset
+// CompilePortFilter and CompileBarePortFilter emit, so the compiled
+// programs can be exercised the same way the kernel would run them,
+// without needing SO_ATTACH_FILTER or a real socket.
+func runBPF(t *testing.T, prog []bpfInstruction, pkt []byte) uint32 {
+	t.Helper()
+
+	var a, x uint32
+	pc := 0
+	for {
+		if pc < 0 || pc >= len(prog) {
+			t.Fatalf("runBPF: pc %d out of range (program length %d)", pc, len(prog))
+		}
+		ins := prog[pc]
+
+		switch ins.Op {
+		case bpfLd | bpfH | bpfAbs:
+			a = uint32(binary.BigEndian.Uint16(pkt[ins.K : ins.K+2]))
+		case bpfLd | bpfB | bpfAbs:
+			a = uint32(pkt[ins.K])
+		case bpfLd | bpfH | bpfInd:
+			off := x + ins.K
+			a = uint32(binary.BigEndian.Uint16(pkt[off : off+2]))
+		case bpfLdx | bpfB | bpfMsh:
+			x = uint32(pkt[ins.K]&0x0F) * 4
+		case bpfJmp | bpfJeq | bpfK:
+			if a == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+			pc++
+			continue
+		case bpfJmp | bpfJset | bpfK:
+			if a&ins.K != 0 {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+			pc++
+			continue
+		case bpfRet | bpfK:
+			return ins.K
+		default:
+			t.Fatalf("runBPF: unhandled opcode %#x", ins.Op)
+		}
+		pc++
+	}
+}
+
+// ipv4TCPFrame builds a minimal Ethernet+IPv4+TCP frame (no options) for
+// exercising CompilePortFilter. notFirstFragment sets a non-zero fragment
+// offset, as a later fragment of a fragmented packet would have -- such a
+// fragment carries no TCP header of its own, so CompilePortFilter is
+// expected to drop it regardless of port.
+func ipv4TCPFrame(proto byte, notFirstFragment bool, srcPort, destPort uint16) []byte {
+	frame := make([]byte, ethHeaderLen+20+20)
+	binary.BigEndian.PutUint16(frame[12:14], ethPIPv4)
+
+	ip := frame[ethHeaderLen:]
+	ip[0] = 0x45
+	ip[9] = proto
+	if notFirstFragment {
+		binary.BigEndian.PutUint16(ip[6:8], 1) // fragment offset = 1 (in 8-byte units)
+	}
+	copy(ip[12:16], net.ParseIP("10.0.0.1").To4())
+	copy(ip[16:20], net.ParseIP("10.0.0.2").To4())
+
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], destPort)
+
+	return frame
+}
+
+func TestCompilePortFilter(t *testing.T) {
+	prog := CompilePortFilter(80)
+
+	const accept, drop = uint32(65535), uint32(0)
+
+	cases := []struct {
+		name  string
+		frame []byte
+		want  uint32
+	}{
+		{"matching dest port", ipv4TCPFrame(6, false, 53211, 80), accept},
+		{"matching src port", ipv4TCPFrame(6, false, 80, 53211), accept},
+		{"non-matching ports", ipv4TCPFrame(6, false, 53211, 8080), drop},
+		{"non-TCP protocol", ipv4TCPFrame(17, false, 53211, 80), drop},
+		{"non-first fragment", ipv4TCPFrame(6, true, 53211, 80), drop},
+		{"IPv6 always accepted", func() []byte {
+			frame := make([]byte, ethHeaderLen+40)
+			binary.BigEndian.PutUint16(frame[12:14], ethPIPv6)
+			return frame
+		}(), accept},
+		{"neither IPv4 nor IPv6", func() []byte {
+			frame := make([]byte, ethHeaderLen+20)
+			binary.BigEndian.PutUint16(frame[12:14], 0x0806) // ARP
+			return frame
+		}(), drop},
+	}
+
+	for _, c := range cases {
+		if got := runBPF(t, prog, c.frame); got != c.want {
+			t.Errorf("%s: CompilePortFilter result = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCompileBarePortFilter(t *testing.T) {
+	prog := CompileBarePortFilter(80)
+
+	const accept, drop = uint32(65535), uint32(0)
+
+	cases := []struct {
+		name    string
+		segment []byte
+		want    uint32
+	}{
+		{"matching dest port", rawTCPHeader(53211, 80), accept},
+		{"matching src port", rawTCPHeader(80, 53211), accept},
+		{"non-matching ports", rawTCPHeader(53211, 8080), drop},
+	}
+
+	for _, c := range cases {
+		if got := runBPF(t, prog, c.segment); got != c.want {
+			t.Errorf("%s: CompileBarePortFilter result = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
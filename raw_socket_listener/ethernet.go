@@ -0,0 +1,76 @@
+package rawSocket
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// Ethernet/IP constants needed to strip a link-layer frame down to a bare
+// TCP segment. Shared by the AF_PACKET backend (af_packet_linux.go) and the
+// pcap replay backend (pcap.go), both of which read whole Ethernet frames
+// rather than the bare TCP segments ip4:tcp/ip6:tcp RAW_SOCKET conns hand
+// to readRAWSocket.
+const (
+	ethHeaderLen = 14 // destination MAC + source MAC + EtherType
+	ethPIPv4     = 0x0800
+	ethPIPv6     = 0x86DD
+)
+
+// stripEthIP removes the Ethernet header and, when present, the IPv4/IPv6
+// header from a captured frame, returning the bare TCP segment along with
+// both endpoints' IP addresses. Both are returned -- rather than just the
+// source, as ip4:tcp/ip6:tcp sockets hand readRAWSocket for free -- because
+// a single frame can be either a request (peer is the source) or a
+// response (peer is the destination), and the caller can't tell which
+// until it has parsed the TCP header; see peerAddr.
+func stripEthIP(frame []byte) (tcp []byte, srcAddr, dstAddr net.Addr, ok bool) {
+	if len(frame) < ethHeaderLen {
+		return nil, nil, nil, false
+	}
+
+	etherType := binary.BigEndian.Uint16(frame[12:14])
+	ipPacket := frame[ethHeaderLen:]
+
+	switch etherType {
+	case ethPIPv4:
+		if len(ipPacket) < 20 {
+			return nil, nil, nil, false
+		}
+		ihl := int(ipPacket[0]&0x0F) * 4
+		if len(ipPacket) < ihl {
+			return nil, nil, nil, false
+		}
+		return ipPacket[ihl:],
+			&net.IPAddr{IP: net.IP(ipPacket[12:16])},
+			&net.IPAddr{IP: net.IP(ipPacket[16:20])},
+			true
+
+	case ethPIPv6:
+		const ipv6HeaderLen = 40
+		if len(ipPacket) < ipv6HeaderLen {
+			return nil, nil, nil, false
+		}
+		return ipPacket[ipv6HeaderLen:],
+			&net.IPAddr{IP: net.IP(ipPacket[8:24])},
+			&net.IPAddr{IP: net.IP(ipPacket[24:40])},
+			true
+
+	default:
+		return nil, nil, nil, false
+	}
+}
+
+// peerAddr picks whichever of srcAddr/dstAddr is the "client" side of
+// tcpBuf -- the side that is not port -- so that callers always hand
+// ParseTCPPacket the same client address for both directions of a
+// connection, regardless of which direction tcpBuf happens to be. Without
+// this, a request and its response resolve to different connKeys and
+// StreamAssembler.match can never pair them. tcpBuf must already have
+// passed isValidPacket, so it's guaranteed to be at least 4 bytes long.
+func peerAddr(tcpBuf []byte, port uint16, srcAddr, dstAddr net.Addr) net.Addr {
+	destPort := binary.BigEndian.Uint16(tcpBuf[2:4])
+	if destPort == port {
+		return srcAddr
+	}
+	return dstAddr
+}
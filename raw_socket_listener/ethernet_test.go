@@ -0,0 +1,109 @@
+package rawSocket
+
+import (
+	"net"
+	"testing"
+)
+
+// ethFrame builds a minimal Ethernet+IPv4 frame wrapping tcpSegment, with
+// just enough of the IP header set (no options, no real checksum) for
+// stripEthIP to parse it.
+func ethFrame(etherType uint16, srcIP, dstIP string, tcpSegment []byte) []byte {
+	frame := make([]byte, ethHeaderLen+20+len(tcpSegment))
+	frame[12] = byte(etherType >> 8)
+	frame[13] = byte(etherType)
+
+	ip := frame[ethHeaderLen:]
+	ip[0] = 0x45 // version 4, IHL 5 (20 bytes, no options)
+	copy(ip[12:16], net.ParseIP(srcIP).To4())
+	copy(ip[16:20], net.ParseIP(dstIP).To4())
+	copy(ip[20:], tcpSegment)
+
+	return frame
+}
+
+func TestStripEthIP_IPv4(t *testing.T) {
+	segment := rawTCPHeader(53211, 80)
+	frame := ethFrame(ethPIPv4, "10.0.0.1", "10.0.0.2", segment)
+
+	tcp, srcAddr, dstAddr, ok := stripEthIP(frame)
+	if !ok {
+		t.Fatal("stripEthIP: expected ok")
+	}
+	if len(tcp) != len(segment) {
+		t.Fatalf("stripEthIP: tcp len = %d, want %d", len(tcp), len(segment))
+	}
+	if srcAddr.String() != "10.0.0.1" {
+		t.Errorf("stripEthIP: srcAddr = %v, want 10.0.0.1", srcAddr)
+	}
+	if dstAddr.String() != "10.0.0.2" {
+		t.Errorf("stripEthIP: dstAddr = %v, want 10.0.0.2", dstAddr)
+	}
+}
+
+func TestStripEthIP_IPv6(t *testing.T) {
+	segment := rawTCPHeader(53211, 80)
+	frame := make([]byte, ethHeaderLen+40+len(segment))
+	frame[12] = byte(ethPIPv6 >> 8)
+	frame[13] = byte(ethPIPv6 & 0xFF)
+
+	ip := frame[ethHeaderLen:]
+	copy(ip[8:24], net.ParseIP("2001:db8::1").To16())
+	copy(ip[24:40], net.ParseIP("2001:db8::2").To16())
+	copy(ip[40:], segment)
+
+	tcp, srcAddr, dstAddr, ok := stripEthIP(frame)
+	if !ok {
+		t.Fatal("stripEthIP: expected ok")
+	}
+	if len(tcp) != len(segment) {
+		t.Fatalf("stripEthIP: tcp len = %d, want %d", len(tcp), len(segment))
+	}
+	if srcAddr.String() != "2001:db8::1" {
+		t.Errorf("stripEthIP: srcAddr = %v, want 2001:db8::1", srcAddr)
+	}
+	if dstAddr.String() != "2001:db8::2" {
+		t.Errorf("stripEthIP: dstAddr = %v, want 2001:db8::2", dstAddr)
+	}
+}
+
+func TestStripEthIP_Rejects(t *testing.T) {
+	cases := []struct {
+		name  string
+		frame []byte
+	}{
+		{"too short for an Ethernet header", make([]byte, ethHeaderLen-1)},
+		{"unknown EtherType", ethFrame(0x1234, "10.0.0.1", "10.0.0.2", rawTCPHeader(1, 2))},
+		{"truncated IPv4 header", func() []byte {
+			frame := make([]byte, ethHeaderLen+10)
+			frame[12] = byte(ethPIPv4 >> 8)
+			frame[13] = byte(ethPIPv4 & 0xFF)
+			return frame
+		}()},
+	}
+
+	for _, c := range cases {
+		if _, _, _, ok := stripEthIP(c.frame); ok {
+			t.Errorf("%s: stripEthIP: expected not ok", c.name)
+		}
+	}
+}
+
+func TestPeerAddr(t *testing.T) {
+	src := &net.IPAddr{IP: net.ParseIP("10.0.0.1")}
+	dst := &net.IPAddr{IP: net.ParseIP("10.0.0.2")}
+
+	// destPort == port: tcpBuf is a request arriving at port, so the peer
+	// is whoever sent it (srcAddr).
+	request := rawTCPHeader(53211, 80)
+	if got := peerAddr(request, 80, src, dst); got != src {
+		t.Errorf("peerAddr(request) = %v, want srcAddr %v", got, src)
+	}
+
+	// destPort != port: tcpBuf is a response leaving port, so the peer is
+	// whoever it's addressed to (dstAddr).
+	response := rawTCPHeader(80, 53211)
+	if got := peerAddr(response, 80, src, dst); got != dst {
+		t.Errorf("peerAddr(response) = %v, want dstAddr %v", got, dst)
+	}
+}
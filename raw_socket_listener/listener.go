@@ -8,38 +8,77 @@ RAW_SOCKET allow you listen for traffic on any port (e.g. sniffing) because they
 
 Ports is TCP feature, same as flow control, reliable transmission and etc.
 
-This package implements own TCP layer: TCP packets is parsed using tcp_packet.go, and flow control is managed by tcp_message.go
+This package implements own TCP layer: TCP packets is parsed using tcp_packet.go, individual messages are
+represented by tcp_message.go, and stream reassembly (ordering, retransmits, HTTP framing and request/response
+pairing) is handled by StreamAssembler in stream_assembler.go.
 */
 package rawSocket
 
 import (
-	"bytes"
 	"encoding/binary"
 	"log"
 	"net"
+	"os"
+	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-)
 
-// Listener handle traffic capture
-type Listener struct {
-	// buffer of TCPMessages waiting to be send
-	// ID -> TCPMessage
-	messages map[string]*TCPMessage
+	"github.com/google/gopacket/pcapgo"
+)
 
-	// Expect: 100-continue request is send in 2 tcp messages
-	// We store ACK aliases to merge this packets together
-	ackAliases map[uint32]uint32
-	// To get ACK of second message we need to compute its Seq and wait for them message
-	seqWithData map[uint32]uint32
+// CaptureMode selects which low-level mechanism Listener uses to pull
+// packets off the wire.
+type CaptureMode int
+
+const (
+	// CaptureModeRAW reads whole TCP segments off ip4:tcp/ip6:tcp
+	// RAW_SOCKET conns, same as the original implementation.
+	CaptureModeRAW CaptureMode = iota
+
+	// CaptureModeAFPacket opens a pool of AF_PACKET sockets joined to a
+	// single PACKET_FANOUT group in PACKET_FANOUT_HASH mode, so the
+	// kernel spreads load across FanoutSize reader goroutines while
+	// keeping each 5-tuple pinned to one socket. Linux only.
+	CaptureModeAFPacket
+
+	// CaptureModePCAP replays packets from a pcap/pcapng file instead of
+	// capturing live traffic. Only reachable via NewListenerFromPCAP,
+	// since it needs a file path rather than anything in ListenerOptions.
+	CaptureModePCAP
+)
 
-	// Ack -> Req
-	respAliases map[uint32]*request
+// DropPolicy selects what a capture backend does with a freshly parsed
+// packet when packetsChan is full, i.e. when the listen() goroutine can't
+// keep up with capture.
+type DropPolicy int
+
+const (
+	// DropOldest discards the longest-queued packet to make room for the
+	// new one, so the assembler always sees the most recent traffic at
+	// the cost of a gap further back in each affected stream.
+	DropOldest DropPolicy = iota
+
+	// DropNewest discards the packet that just arrived, leaving whatever
+	// is already queued untouched.
+	DropNewest
+
+	// Block waits for room in packetsChan, the original behavior. This
+	// applies real backpressure all the way back to the kernel socket
+	// buffer, which is what makes packets-dropped-by-kernel countable via
+	// SO_RXQ_OVFL/PACKET_STATISTICS in the first place.
+	Block
+)
 
-	// Ack -> ID
-	respWithoutReq map[uint32]string
+// Listener handle traffic capture
+type Listener struct {
+	// assembler owns per-flow TCP reassembly: ordering, retransmit/dup
+	// detection, HTTP framing and request/response pairing. It replaces
+	// the old ack-alias maps entirely.
+	assembler *StreamAssembler
 
 	// Messages ready to be send to client
 	packetsChan chan *TCPPacket
@@ -47,35 +86,165 @@ type Listener struct {
 	// Messages ready to be send to client
 	messagesChan chan *TCPMessage
 
-	addr string // IP to listen
+	addr string // IP to listen; "", an IPv4 literal, or an IPv6 literal (e.g. "::")
 	port uint16 // Port to listen
 
 	messageExpire time.Duration
 
-	conn net.PacketConn
+	// captureMode picks between the single RAW_SOCKET reader and the
+	// multi-socket AF_PACKET/FANOUT reader.
+	captureMode CaptureMode
+
+	// FanoutSize is the number of AF_PACKET sockets joined to the fanout
+	// group. Ignored unless captureMode is CaptureModeAFPacket. Defaults
+	// to runtime.GOMAXPROCS(0) when zero.
+	FanoutSize int
+
+	// kernelFilter enables compiling and attaching a classic BPF program
+	// to the capture socket via SO_ATTACH_FILTER, so the kernel drops
+	// non-matching packets before they are copied into userspace.
+	// isValidPacket still runs afterwards, both as a fallback for
+	// kernels/socket types that don't support SO_ATTACH_FILTER and to
+	// finish the job BPF leaves incomplete (e.g. IPv6 port matching).
+	kernelFilter bool
+
+	// conn4 and conn6 are the ip4:tcp/ip6:tcp RAW_SOCKET conns read by
+	// readRAWSocket. CaptureModeRAW listens on both families at once
+	// unless addr pins it to one of them, so that dual-stack traffic on
+	// t.port is captured regardless of which family a given peer uses.
+	conn4 net.PacketConn
+	conn6 net.PacketConn
+
+	// rawPeerAddrs remembers, for CaptureModeRAW, the client address seen
+	// on the request half of each connection, keyed by the client's port.
+	// ip4:tcp/ip6:tcp ReadFrom only ever reports the IP source address of
+	// a segment, which is the client's address for a request but this
+	// host's own address for a response (see resolveRAWPeerAddr), so the
+	// response side has no other way to recover it. Guarded by
+	// rawPeerAddrsMu since conn4 and conn6 are read by separate reader
+	// goroutines. Keyed by a uint16 port, so it's naturally capped at 64k
+	// entries and needs no separate eviction.
+	rawPeerAddrs   map[uint16]net.Addr
+	rawPeerAddrsMu sync.Mutex
+
 	quit chan bool
+
+	// afPacketSockets holds the fds opened by the AF_PACKET backend, so
+	// Close can shut them all down.
+	afPacketSockets []int
+
+	// pcapWriter and pcapFile back WritePCAP, teeing every raw frame the
+	// active backend observes to a pcap file for later offline analysis.
+	// Guarded by pcapMu since packets can arrive on multiple reader
+	// goroutines (e.g. the AF_PACKET fanout workers).
+	pcapMu     sync.Mutex
+	pcapWriter *pcapgo.Writer
+	pcapFile   *os.File
+
+	// dropPolicy governs enqueuePacket's behavior when packetsChan is
+	// full. Reader goroutines call enqueuePacket instead of sending on
+	// packetsChan directly so this is enforced uniformly across backends.
+	dropPolicy DropPolicy
+
+	// Counters backing Stats(). All are updated with sync/atomic since
+	// packets arrive on reader goroutines (possibly several, under
+	// CaptureModeAFPacket) while Stats() can be called from any
+	// goroutine.
+	statPacketsReceived      int64
+	statPacketsDroppedPolicy int64
+	statPacketsDroppedKernel int64
 }
 
-type request struct {
-	start time.Time
-	ack   uint32
+// ListenerOptions groups the tunables accepted by NewListenerWithOptions.
+// The zero value selects CaptureModeRAW with no kernel-side filtering,
+// the DropOldest policy, and no resource limits.
+type ListenerOptions struct {
+	// CaptureMode picks the capture backend.
+	CaptureMode CaptureMode
+
+	// FanoutSize is the number of AF_PACKET sockets to join to the
+	// fanout group. Ignored for CaptureModeRAW. Defaults to
+	// runtime.GOMAXPROCS(0) when zero.
+	FanoutSize int
+
+	// KernelFilter attaches a classic BPF program, matching t.port, to
+	// the capture socket via SO_ATTACH_FILTER, so the kernel drops
+	// non-matching packets before they reach userspace. When the
+	// running kernel or socket type doesn't support SO_ATTACH_FILTER,
+	// Listener logs a warning and falls back to filtering purely in
+	// isValidPacket.
+	KernelFilter bool
+
+	// DropPolicy controls what happens to a newly captured packet when
+	// packetsChan is full. Defaults to DropOldest (zero value), since a
+	// slow consumer should lose old data rather than either blocking the
+	// kernel queue (Block) or discarding what just arrived (DropNewest).
+	DropPolicy DropPolicy
+
+	// MaxInFlightMessages bounds how many TCP connections the
+	// StreamAssembler tracks at once. Beyond this, the least-recently-active
+	// connection is evicted to make room; its in-progress messages, if any,
+	// are discarded, not delivered, and counted in Stats().MessagesEvicted.
+	// Zero means unlimited.
+	MaxInFlightMessages int
+
+	// MaxPacketsPerMessage bounds how many packets a single in-progress
+	// TCPMessage may accumulate before it's forcibly flushed as complete
+	// and counted as evicted rather than grown further. Zero means
+	// unlimited. Guards against a message that never frames correctly
+	// (e.g. a missed Content-Length) consuming memory forever.
+	MaxPacketsPerMessage int
+
+	// MaxMessageBytes is the same bound as MaxPacketsPerMessage, but on
+	// total bytes (headers and body) buffered for a single in-progress
+	// TCPMessage. Zero means unlimited.
+	MaxMessageBytes int
 }
 
-// NewListener creates and initializes new Listener object
-func NewListener(addr string, port string, expire time.Duration) (l *Listener) {
+// Stats reports Listener's capture-health counters. All are cumulative
+// since the Listener was created.
+type Stats struct {
+	// PacketsReceived counts packets that reached enqueuePacket, i.e.
+	// survived isValidPacket/BPF filtering.
+	PacketsReceived uint64
+
+	// PacketsDroppedKernel counts packets the kernel dropped before
+	// userspace ever saw them. Populated via getsockopt(PACKET_STATISTICS)
+	// under CaptureModeAFPacket; always zero under CaptureModeRAW, since
+	// SO_RXQ_OVFL requires switching from net.PacketConn's ReadFrom to
+	// raw recvmsg+cmsg handling that Go's net package doesn't expose.
+	PacketsDroppedKernel uint64
+
+	// PacketsDroppedPolicy counts packets enqueuePacket discarded under
+	// DropOldest/DropNewest because packetsChan was full.
+	PacketsDroppedPolicy uint64
+
+	// MessagesExpired counts in-progress messages flushed by the GC
+	// ticker because they sat longer than messageExpire, most often
+	// responses with no Content-Length framed by connection-close.
+	MessagesExpired uint64
+
+	// MessagesEvicted counts in-progress messages flushed early because
+	// they hit MaxPacketsPerMessage or MaxMessageBytes, in-progress
+	// messages discarded with a connection evicted under
+	// MaxInFlightMessages, and dispatched requests dropped from a
+	// connection's response-pairing queue once it is full.
+	MessagesEvicted uint64
+}
+
+// newBaseListener allocates a Listener and initializes the state every
+// capture backend needs (channels, the assembler, the port and expiry),
+// without picking a backend or starting any goroutines.
+func newBaseListener(port string, expire time.Duration) (l *Listener) {
 	l = &Listener{}
 
 	l.packetsChan = make(chan *TCPPacket, 10000)
 	l.messagesChan = make(chan *TCPMessage, 10000)
 	l.quit = make(chan bool)
 
-	l.messages = make(map[string]*TCPMessage)
-	l.ackAliases = make(map[uint32]uint32)
-	l.seqWithData = make(map[uint32]uint32)
-	l.respAliases = make(map[uint32]*request)
-	l.respWithoutReq = make(map[uint32]string)
+	l.assembler = NewStreamAssembler()
+	l.rawPeerAddrs = make(map[uint16]net.Addr)
 
-	l.addr = addr
 	_port, _ := strconv.Atoi(port)
 	l.port = uint16(_port)
 
@@ -85,93 +254,166 @@ func NewListener(addr string, port string, expire time.Duration) (l *Listener) {
 
 	l.messageExpire = expire
 
+	return
+}
+
+// NewListener creates and initializes new Listener object
+func NewListener(addr string, port string, expire time.Duration) (l *Listener) {
+	return NewListenerWithOptions(addr, port, expire, ListenerOptions{})
+}
+
+// NewListenerWithOptions creates and initializes new Listener object, with
+// an explicit choice of capture backend and filtering. See ListenerOptions.
+func NewListenerWithOptions(addr string, port string, expire time.Duration, options ListenerOptions) (l *Listener) {
+	l = newBaseListener(port, expire)
+
+	l.addr = addr
+
+	l.captureMode = options.CaptureMode
+	l.FanoutSize = options.FanoutSize
+	if l.FanoutSize == 0 {
+		l.FanoutSize = runtime.GOMAXPROCS(0)
+	}
+	l.kernelFilter = options.KernelFilter
+	l.dropPolicy = options.DropPolicy
+
+	l.assembler.maxInFlightMessages = options.MaxInFlightMessages
+	l.assembler.maxPacketsPerMessage = options.MaxPacketsPerMessage
+	l.assembler.maxMessageBytes = options.MaxMessageBytes
+
 	go l.listen()
 
 	// Special case for testing
 	if l.port != 0 {
-		go l.readRAWSocket()
+		switch l.captureMode {
+		case CaptureModeAFPacket:
+			go l.readAFPacket()
+		default:
+			go l.readRAWSocket()
+		}
 	}
 
 	return
 }
 
+// NewListenerFromPCAP creates a Listener that replays packets from a
+// pcap/pcapng file at path instead of capturing live traffic. It runs the
+// same StreamAssembler as a live Listener, so it's a deterministic way to
+// unit-test reassembly, or to reproduce a bug from a window captured live
+// with `tcpdump -w`.
+func NewListenerFromPCAP(path string, port string, expire time.Duration) (l *Listener) {
+	l = newBaseListener(port, expire)
+	l.captureMode = CaptureModePCAP
+
+	go l.listen()
+	go l.readPCAP(path)
+
+	return
+}
+
 func (t *Listener) listen() {
 	gcTicker := time.Tick(t.messageExpire / 2)
 
 	for {
 		select {
 		case <-t.quit:
-			if t.conn != nil {
-				t.conn.Close()
+			if t.conn4 != nil {
+				t.conn4.Close()
+			}
+			if t.conn6 != nil {
+				t.conn6.Close()
 			}
 			return
 		// We need to use channels to process each packet to avoid data races
 		case packet := <-t.packetsChan:
-			maxLen := len(packet.Data)
-			if maxLen > 500 {
-				maxLen = 500
-			}
-
 			t.processTCPPacket(packet)
 
 		case <-gcTicker:
-			now := time.Now()
-
-			for _, message := range t.messages {
-				if now.Sub(message.Start) >= t.messageExpire {
-					t.dispatchMessage(message)
-				}
+			for _, message := range t.assembler.Expire(t.messageExpire) {
+				t.messagesChan <- message
 			}
 		}
 	}
 }
 
-func (t *Listener) dispatchMessage(message *TCPMessage) {
-	delete(t.ackAliases, message.Ack)
-	delete(t.messages, message.ID)
-
-	if message.IsIncoming {
-		// If there were response before request
-		if respID, ok := t.respWithoutReq[message.ResponseAck]; ok {
-			if resp, rok := t.messages[respID]; rok {
-				if resp.RequestAck == 0 {
-					resp.RequestAck = message.Ack
-					resp.RequestStart = message.Start
-
-					if resp.IsFinished() {
-						defer t.dispatchMessage(resp)
-					}
-				}
-			}
+// processTCPPacket hands packet to the StreamAssembler and forwards
+// whatever messages it completes (a request, a paired response, or both)
+// to messagesChan.
+func (t *Listener) processTCPPacket(packet *TCPPacket) {
+	// Don't exit on panic
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("PANIC: pkg:", r, packet, string(debug.Stack()))
 		}
-	} else {
-		delete(t.respAliases, message.Ack)
-		delete(t.respWithoutReq, message.Ack)
+	}()
 
-		// Do not track responses which have no associated requests
-		if message.RequestAck == 0 {
-			return
+	isIncoming := packet.DestPort == t.port
+
+	for _, message := range t.assembler.Process(packet, isIncoming) {
+		t.messagesChan <- message
+	}
+}
+
+// readRAWSocket opens an ip4:tcp conn, an ip6:tcp conn, or both -- whichever
+// match t.addr -- and runs a reader goroutine for each, so dual-stack
+// traffic on t.port is captured regardless of which family addr is given
+// as a bare IP address, RAW_SOCKET can't be told "any family", hence the
+// two separate conns.
+func (t *Listener) readRAWSocket() {
+	var wg sync.WaitGroup
+
+	if t.addr == "" || !isIPv6Literal(t.addr) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.readRAWSocketFamily("ip4:tcp", t.addr, &t.conn4)
+		}()
+	}
+
+	if t.addr == "" || isIPv6Literal(t.addr) {
+		v6Addr := t.addr
+		if v6Addr == "" {
+			v6Addr = "::"
 		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.readRAWSocketFamily("ip6:tcp", v6Addr, &t.conn6)
+		}()
 	}
 
-	t.messagesChan <- message
+	wg.Wait()
 }
 
-func (t *Listener) readRAWSocket() {
-	conn, e := net.ListenPacket("ip4:tcp", t.addr)
-	t.conn = conn
+// isIPv6Literal reports whether addr parses as an IP address with no IPv4
+// representation, e.g. "::" or "2001:db8::1".
+func isIPv6Literal(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() == nil
+}
+
+func (t *Listener) readRAWSocketFamily(network, addr string, connField *net.PacketConn) {
+	conn, e := net.ListenPacket(network, addr)
+	*connField = conn
 
 	if e != nil {
 		log.Fatal(e)
 	}
 
-	defer t.conn.Close()
+	if t.kernelFilter {
+		if e := attachBarePortFilter(conn, t.port); e != nil {
+			log.Println("kernel BPF filter not supported on this conn, falling back to userspace filtering:", e)
+		}
+	}
+
+	defer conn.Close()
 
 	buf := make([]byte, 64*1024) // 64kb
 
 	for {
 		// Note: ReadFrom receive messages without IP header
-		n, addr, err := t.conn.ReadFrom(buf)
+		n, addr, err := conn.ReadFrom(buf)
 
 		if err != nil {
 			if strings.HasSuffix(err.Error(), "closed network connection") {
@@ -186,15 +428,61 @@ func (t *Listener) readRAWSocket() {
 				newBuf := make([]byte, n)
 				copy(newBuf, buf[:n])
 
-				go func(newBuf []byte) {
-					t.packetsChan <- ParseTCPPacket(addr, newBuf)
-				}(newBuf)
+				t.teeRaw(newBuf)
+				peer := t.resolveRAWPeerAddr(newBuf, addr)
+				t.enqueuePacket(ParseTCPPacket(peer, newBuf))
 			}
 		}
 	}
 }
 
+// resolveRAWPeerAddr returns the client's address for a segment captured
+// off an ip4:tcp/ip6:tcp RAW_SOCKET conn, regardless of which direction it
+// travelled. addr, as handed back by ReadFrom, is only the client's
+// address for a request -- ReadFrom always reports the IP source address,
+// and a response's source is this host's own address, not the client's --
+// so connKey{addr, port} (see stream_assembler.go) would otherwise put a
+// request and its response in different streams and match() could never
+// pair them, the same bug peerAddr fixes for the AF_PACKET/pcap backends
+// in ethernet.go. Unlike those backends, a bare TCP segment has no IP
+// layer left to read the destination address from, so the client address
+// for the response side is instead recalled from the request that shared
+// its client port.
+func (t *Listener) resolveRAWPeerAddr(tcpBuf []byte, addr net.Addr) net.Addr {
+	destPort := binary.BigEndian.Uint16(tcpBuf[2:4])
+	srcPort := binary.BigEndian.Uint16(tcpBuf[0:2])
+
+	if destPort == t.port {
+		t.rawPeerAddrsMu.Lock()
+		t.rawPeerAddrs[srcPort] = addr
+		t.rawPeerAddrsMu.Unlock()
+		return addr
+	}
+
+	t.rawPeerAddrsMu.Lock()
+	peer, ok := t.rawPeerAddrs[destPort]
+	t.rawPeerAddrsMu.Unlock()
+	if ok {
+		return peer
+	}
+	return addr
+}
+
+// isValidPacket expects buf to be a bare TCP segment, i.e. with any link
+// and IP layer already stripped off (readRAWSocket gets this for free from
+// ip4:tcp sockets, readAFPacket strips it explicitly via stripEthIP). buf
+// isn't guaranteed to actually be TCP -- AF_PACKET's ETH_P_ALL sockets and
+// pcap replay both hand us every IP protocol, not just TCP -- so the
+// length check below must happen before any of the header fields are
+// indexed, not just the dataOffset one.
 func (t *Listener) isValidPacket(buf []byte) bool {
+	// A TCP header is at least 20 bytes; bail out before touching buf[12]
+	// below rather than risk indexing into a short/malformed/non-TCP
+	// buffer (e.g. ICMP, UDP, or a truncated capture).
+	if len(buf) < 20 {
+		return false
+	}
+
 	// To avoid full packet parsing every time, we manually parsing values needed for packet filtering
 	// http://en.wikipedia.org/wiki/Transmission_Control_Protocol
 	destPort := binary.BigEndian.Uint16(buf[2:4])
@@ -216,113 +504,73 @@ func (t *Listener) isValidPacket(buf []byte) bool {
 	return false
 }
 
-var bExpect100ContinueCheck = []byte("Expect: 100-continue")
-var bPOST = []byte("POST")
-
-// Trying to add packet to existing message or creating new message
-//
-// For TCP message unique id is Acknowledgment number (see tcp_packet.go)
-func (t *Listener) processTCPPacket(packet *TCPPacket) {
-	// Don't exit on panic
-	defer func() {
-		if r := recover(); r != nil {
-			log.Println("PANIC: pkg:", r, packet, string(debug.Stack()))
-		}
-	}()
-
-	var message *TCPMessage
-
-	isIncoming := packet.DestPort == t.port
-
-	if parentAck, ok := t.seqWithData[packet.Seq]; ok {
-		t.ackAliases[packet.Ack] = parentAck
-		packet.Ack = parentAck
-		delete(t.seqWithData, packet.Seq)
-	}
-
-	if alias, ok := t.ackAliases[packet.Ack]; ok {
-		packet.Ack = alias
-	}
-
-	var responseRequest *request
-
-	if !isIncoming {
-		responseRequest, _ = t.respAliases[packet.Ack]
-	}
-
-	mID := packet.Addr.String() + strconv.Itoa(int(packet.DestPort)) + strconv.Itoa(int(packet.Ack))
-
-	message, ok := t.messages[mID]
+// Receive TCP messages from the listener channel
+func (t *Listener) Receive() *TCPMessage {
+	return <-t.messagesChan
+}
 
-	if !ok {
-		message = NewTCPMessage(mID, packet.Seq, packet.Ack, isIncoming)
-		t.messages[mID] = message
+// enqueuePacket is the only path a reader goroutine should use to hand a
+// parsed packet to the listen() goroutine, so DropPolicy is enforced the
+// same way regardless of which capture backend is running.
+func (t *Listener) enqueuePacket(packet *TCPPacket) {
+	atomic.AddInt64(&t.statPacketsReceived, 1)
 
-		if !isIncoming {
-			if responseRequest != nil {
-				message.RequestStart = responseRequest.start
-				message.RequestAck = responseRequest.ack
-			} else {
-				t.respWithoutReq[packet.Ack] = mID
-			}
+	switch t.dropPolicy {
+	case DropNewest:
+		select {
+		case t.packetsChan <- packet:
+		default:
+			atomic.AddInt64(&t.statPacketsDroppedPolicy, 1)
 		}
-	}
 
-	// Handling Expect: 100-continue requests
-	if len(packet.Data) > 4 && bytes.Equal(packet.Data[0:4], bPOST) {
-		// reading last 20 bytes (not counting CRLF): last header value (if no body presented)
-		if bytes.Equal(packet.Data[len(packet.Data)-24:len(packet.Data)-4], bExpect100ContinueCheck) {
-			seq := packet.Seq + uint32(len(packet.Data))
-			t.seqWithData[seq] = packet.Ack
-
-			// In case if sequence packet came first
-			for _id, m := range t.messages {
-				if m.Seq == seq {
-					t.ackAliases[m.Ack] = packet.Ack
-
-					for _, pkt := range m.packets {
-						message.AddPacket(pkt)
-					}
-
-					delete(t.messages, _id)
-				}
+	case DropOldest:
+		for {
+			select {
+			case t.packetsChan <- packet:
+				return
+			default:
+			}
+			select {
+			case <-t.packetsChan:
+				atomic.AddInt64(&t.statPacketsDroppedPolicy, 1)
+			default:
 			}
-
-			// Removing `Expect: 100-continue` header
-			packet.Data = append(packet.Data[:len(packet.Data)-24], packet.Data[len(packet.Data)-2:]...)
-		}
-	}
-
-	if isIncoming {
-		// If message have multiple packets, delete previous alias
-		if len(message.packets) > 0 {
-			delete(t.respAliases, message.ResponseAck)
 		}
 
-		responseAck := packet.Seq + uint32(message.BodySize()) + uint32(len(packet.Data))
-		t.respAliases[responseAck] = &request{message.Start, message.Ack}
-
-		message.ResponseAck = responseAck
-	}
-
-	// Adding packet to message
-	message.AddPacket(packet)
-
-	// If message contains only single packet immediately dispatch it
-	if message.IsFinished() {
-		t.dispatchMessage(message)
+	default: // Block
+		t.packetsChan <- packet
 	}
 }
 
-// Receive TCP messages from the listener channel
-func (t *Listener) Receive() *TCPMessage {
-	return <-t.messagesChan
+// Stats reports this Listener's cumulative capture-health counters. Safe
+// to call from any goroutine while the Listener is running.
+func (t *Listener) Stats() Stats {
+	expired, evicted := t.assembler.counters()
+
+	return Stats{
+		PacketsReceived:      uint64(atomic.LoadInt64(&t.statPacketsReceived)),
+		PacketsDroppedKernel: t.kernelDrops(),
+		PacketsDroppedPolicy: uint64(atomic.LoadInt64(&t.statPacketsDroppedPolicy)),
+		MessagesExpired:      expired,
+		MessagesEvicted:      evicted,
+	}
 }
 
 func (t *Listener) Close() {
 	close(t.quit)
-	if t.conn != nil {
-		t.conn.Close()
+	if t.conn4 != nil {
+		t.conn4.Close()
+	}
+	if t.conn6 != nil {
+		t.conn6.Close()
 	}
+	t.closeAFPacket()
+
+	t.pcapMu.Lock()
+	if t.pcapFile != nil {
+		t.pcapFile.Close()
+	}
+	t.pcapMu.Unlock()
+
 	return
 }
@@ -0,0 +1,59 @@
+package rawSocket
+
+import (
+	"net"
+	"testing"
+)
+
+// rawTCPHeader builds the 20-byte fixed part of a bare TCP segment (no
+// link/IP layer, as ip4:tcp/ip6:tcp RAW_SOCKET conns hand readRAWSocket),
+// with just enough set for resolveRAWPeerAddr to read the ports off it.
+func rawTCPHeader(srcPort, destPort uint16) []byte {
+	buf := make([]byte, 20)
+	buf[0] = byte(srcPort >> 8)
+	buf[1] = byte(srcPort)
+	buf[2] = byte(destPort >> 8)
+	buf[3] = byte(destPort)
+	return buf
+}
+
+// TestListener_ResolveRAWPeerAddr is the regression test for the
+// CaptureModeRAW response-pairing bug: ip4:tcp/ip6:tcp ReadFrom reports the
+// IP source address of a segment, which is the client's address for a
+// request but this host's own address for a response, so connKey{addr,
+// port} (stream_assembler.go) would otherwise put requests and responses
+// in different streams. resolveRAWPeerAddr must recover the client address
+// for both directions.
+func TestListener_ResolveRAWPeerAddr(t *testing.T) {
+	l := newBaseListener("80", 0)
+
+	client := &net.IPAddr{IP: net.ParseIP("10.0.0.1")}
+	server := &net.IPAddr{IP: net.ParseIP("127.0.0.1")}
+
+	req := rawTCPHeader(53211, 80)
+	if got := l.resolveRAWPeerAddr(req, client); got.String() != client.String() {
+		t.Fatalf("request: resolveRAWPeerAddr = %v, want %v", got, client)
+	}
+
+	// The response travels in the opposite direction (dest port is the
+	// client's), and ReadFrom would report this host's own address --
+	// never the client's -- as its source.
+	resp := rawTCPHeader(80, 53211)
+	if got := l.resolveRAWPeerAddr(resp, server); got.String() != client.String() {
+		t.Fatalf("response: resolveRAWPeerAddr = %v, want %v (recalled from the request)", got, client)
+	}
+}
+
+// TestListener_ResolveRAWPeerAddrUnknownClient covers a response for a
+// client port resolveRAWPeerAddr never saw a request for (e.g. the request
+// arrived before this Listener started, or was dropped): it has nothing to
+// recall, so it falls back to addr as given rather than failing.
+func TestListener_ResolveRAWPeerAddrUnknownClient(t *testing.T) {
+	l := newBaseListener("80", 0)
+
+	server := &net.IPAddr{IP: net.ParseIP("127.0.0.1")}
+	resp := rawTCPHeader(80, 53211)
+	if got := l.resolveRAWPeerAddr(resp, server); got.String() != server.String() {
+		t.Fatalf("resolveRAWPeerAddr = %v, want fallback %v", got, server)
+	}
+}
@@ -0,0 +1,146 @@
+package rawSocket
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// readPCAP replays every packet in the pcap/pcapng file at path into
+// packetsChan, as if it had just been captured live. It accepts both
+// formats, trying the classic pcap reader first and falling back to the
+// newer pcapng one, since pcapgo exposes them as distinct readers.
+func (t *Listener) readPCAP(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal("readPCAP: ", err)
+	}
+	defer f.Close()
+
+	if r, err := pcapgo.NewReader(f); err == nil {
+		t.readPCAPFrames(r, r.LinkType())
+		return
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.Fatal("readPCAP: ", err)
+	}
+
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		log.Fatal("readPCAP: file is neither valid pcap nor pcapng: ", err)
+	}
+	t.readPCAPFrames(r, r.LinkType())
+}
+
+// readPCAPFrames pulls raw frames out of src and feeds them through the
+// same stripping/filtering path a live backend would use, so the
+// StreamAssembler can't tell the difference between a live capture and a
+// replayed one. linkType tells us whether frames still have an Ethernet
+// header (LinkTypeEthernet, as tcpdump -i produces) or, for any other link
+// type, are taken to be bare TCP segments (LinkTypeRaw, as written by our
+// own WritePCAP in RAW mode).
+func (t *Listener) readPCAPFrames(src gopacket.PacketDataSource, linkType layers.LinkType) {
+	for {
+		data, _, err := src.ReadPacketData()
+		if err != nil {
+			// io.EOF is the expected, successful end of a file.
+			return
+		}
+
+		var tcpBuf []byte
+		var srcAddr, dstAddr net.Addr
+		var ok bool
+
+		switch linkType {
+		case layers.LinkTypeEthernet:
+			tcpBuf, srcAddr, dstAddr, ok = stripEthIP(data)
+		default:
+			// Already a bare TCP segment, as our RAW backend tees it,
+			// which never had a separate src/dst address to begin with.
+			tcpBuf, srcAddr, dstAddr, ok = data, &net.IPAddr{}, &net.IPAddr{}, true
+		}
+
+		if !ok || !t.isValidPacket(tcpBuf) {
+			continue
+		}
+
+		// Tee the frame exactly as read from src -- a bare segment for
+		// LinkTypeRaw, a full Ethernet frame otherwise -- so a
+		// replay-then-WritePCAP round trip reproduces the source file
+		// instead of silently writing an empty one.
+		teeBuf := make([]byte, len(data))
+		copy(teeBuf, data)
+		t.teeRaw(teeBuf)
+
+		newBuf := make([]byte, len(tcpBuf))
+		copy(newBuf, tcpBuf)
+
+		addr := peerAddr(tcpBuf, t.port, srcAddr, dstAddr)
+		t.enqueuePacket(ParseTCPPacket(addr, newBuf))
+	}
+}
+
+// teeRaw writes data to the active WritePCAP file, if any. It's a no-op
+// until WritePCAP has been called, so live capture has no cost by default.
+func (t *Listener) teeRaw(data []byte) {
+	t.pcapMu.Lock()
+	defer t.pcapMu.Unlock()
+
+	if t.pcapWriter == nil {
+		return
+	}
+
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Now(),
+		CaptureLength: len(data),
+		Length:        len(data),
+	}
+	if err := t.pcapWriter.WritePacket(ci, data); err != nil {
+		log.Println("WritePCAP: ", err)
+	}
+}
+
+// WritePCAP tees every packet this Listener captures from here on to a new
+// pcap file at path, so a live run can later be replayed deterministically
+// with NewListenerFromPCAP -- useful for offline bug reproduction from a
+// tricky flow, or for building a regression fixture for the assembler.
+func (t *Listener) WritePCAP(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := pcapgo.NewWriter(f)
+
+	// The RAW backend only ever sees bare TCP segments (ip4:tcp/ip6:tcp
+	// strip the link and IP layers before we get them), so it can't
+	// produce a real LinkTypeEthernet or LinkTypeIPv4 capture. Recording
+	// it as LinkTypeRaw keeps the file honest about what's actually in
+	// it; readPCAP knows to treat LinkTypeRaw frames as already-stripped.
+	linkType := layers.LinkTypeEthernet
+	if t.captureMode == CaptureModeRAW {
+		linkType = layers.LinkTypeRaw
+	}
+
+	if err := w.WriteFileHeader(65536, linkType); err != nil {
+		f.Close()
+		return err
+	}
+
+	t.pcapMu.Lock()
+	if t.pcapFile != nil {
+		t.pcapFile.Close()
+	}
+	t.pcapFile = f
+	t.pcapWriter = w
+	t.pcapMu.Unlock()
+
+	return nil
+}
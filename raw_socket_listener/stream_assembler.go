@@ -0,0 +1,545 @@
+package rawSocket
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	bCRLFCRLF                = []byte("\r\n\r\n")
+	bCRLF                    = []byte("\r\n")
+	bTransferEncodingChunked = []byte("transfer-encoding: chunked")
+	bContentLength           = []byte("content-length:")
+)
+
+// connKey identifies one TCP connection by the peer's IP and its port that
+// is *not* t.port -- i.e. the client port, which both directions of a
+// connection agree on (a request's SrcPort equals a response's DestPort).
+// Unlike the old per-message Ack-based id, this stays stable for the whole
+// life of the connection, so pipelined or keep-alive messages on the same
+// connection can never be confused with messages on a different one.
+type connKey struct {
+	addr string
+	port uint16
+}
+
+// directionBuffer reassembles one direction (client->server or
+// server->client) of a single connection into a byte stream, folds in
+// HTTP framing, and owns the in-progress TCPMessage.
+type directionBuffer struct {
+	started bool
+	nextSeq uint32
+
+	// reorder holds segments that arrived ahead of nextSeq, bounded by
+	// StreamAssembler.reorderWindow bytes.
+	reorder       map[uint32]*TCPPacket
+	bufferedBytes int
+
+	message *TCPMessage
+
+	// packetCount counts the packets folded into message so far, checked
+	// against StreamAssembler.maxPacketsPerMessage.
+	packetCount int
+
+	// raw accumulates the bytes of the in-progress message purely to
+	// detect HTTP framing; the message's own bytes live in its packets,
+	// added via TCPMessage.AddPacket as before.
+	raw           []byte
+	headerLen     int // 0 until the blank line ending headers is seen
+	chunked       bool
+	contentLength int  // -1 until known, 0 means no body
+	provisional   bool // true once a 1xx status line is detected
+}
+
+func newDirectionBuffer() *directionBuffer {
+	return &directionBuffer{
+		reorder:       make(map[uint32]*TCPPacket),
+		contentLength: -1,
+	}
+}
+
+func (d *directionBuffer) reset() {
+	d.message = nil
+	d.packetCount = 0
+	d.raw = nil
+	d.headerLen = 0
+	d.chunked = false
+	d.contentLength = -1
+	d.provisional = false
+}
+
+// tcpStream holds the reassembly state for both directions of a single
+// connection, plus the httpMatcher queue used to pair requests and
+// responses.
+type tcpStream struct {
+	req, resp *directionBuffer
+
+	// pending holds completed requests not yet paired with a response,
+	// oldest first, so that pipelined/keep-alive requests on the same
+	// connection are matched to responses in the order they were sent.
+	pending []*TCPMessage
+
+	lastActivity time.Time
+}
+
+func newTCPStream() *tcpStream {
+	return &tcpStream{req: newDirectionBuffer(), resp: newDirectionBuffer()}
+}
+
+// StreamAssembler reassembles raw TCP segments into complete HTTP
+// request/response TCPMessages, modeled after the approach used by
+// gopacket's tcpassembly/reassembly package: per-flow state keeps a
+// reordering buffer keyed by sequence number, drops retransmitted bytes,
+// and only calls a message complete once HTTP framing (Content-Length,
+// chunked encoding, or - for requests without a body - end of headers)
+// says so, rather than dispatching on the first packet seen.
+//
+// It replaces Listener's old ackAliases/seqWithData/respAliases/
+// respWithoutReq maps, which keyed in-flight messages by TCP Ack number;
+// that scheme collided whenever two messages (on the same or different
+// connections from the same peer IP) happened to share an Ack value.
+// Connections are now identified by connKey (peer IP + peer port), which
+// cannot collide across connections, and request/response pairing is done
+// by a small FIFO matcher (similar in spirit to gopacket's httpMatcher)
+// rather than by predicting the response's Ack number up front.
+type StreamAssembler struct {
+	// reorderWindow bounds how many out-of-order bytes a direction will
+	// buffer while waiting for a gap to fill before giving up on it and
+	// resyncing forward, accepting the loss.
+	reorderWindow int
+
+	// maxInFlightMessages bounds how many connections (streams) are
+	// tracked at once; beyond that, the least-recently-active one is
+	// evicted to make room. Zero means unlimited. Set from
+	// ListenerOptions.MaxInFlightMessages.
+	maxInFlightMessages int
+
+	// maxPacketsPerMessage and maxMessageBytes bound a single in-progress
+	// message's size; exceeding either forces it to flush early as
+	// evicted rather than grow forever. Zero means unlimited. Set from
+	// the matching ListenerOptions fields.
+	maxPacketsPerMessage int
+	maxMessageBytes      int
+
+	// messagesExpired and messagesEvicted back Listener.Stats(). They're
+	// updated from the single listen() goroutine but read from whichever
+	// goroutine calls Stats(), hence atomic.
+	messagesExpired int64
+	messagesEvicted int64
+
+	streams map[connKey]*tcpStream
+}
+
+// NewStreamAssembler creates a StreamAssembler ready to accept packets,
+// with no resource limits; set the max* fields directly to bound memory
+// (done by Listener, from ListenerOptions, right after construction).
+func NewStreamAssembler() *StreamAssembler {
+	return &StreamAssembler{
+		reorderWindow: 64 * 1024,
+		streams:       make(map[connKey]*tcpStream),
+	}
+}
+
+// counters returns the cumulative expired/evicted message counts backing
+// Listener.Stats().
+func (a *StreamAssembler) counters() (expired, evicted uint64) {
+	return uint64(atomic.LoadInt64(&a.messagesExpired)), uint64(atomic.LoadInt64(&a.messagesEvicted))
+}
+
+func keyForPacket(packet *TCPPacket, isIncoming bool) connKey {
+	if isIncoming {
+		return connKey{addr: packet.Addr.String(), port: packet.SrcPort}
+	}
+	return connKey{addr: packet.Addr.String(), port: packet.DestPort}
+}
+
+// seqLess reports whether a comes before b in sequence-number space,
+// correctly handling wraparound (RFC 793 sec. 3.3).
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// Process feeds one captured TCP segment into the assembler and returns
+// zero or more TCPMessages that are now fully framed and ready to
+// dispatch. A completed response is returned already paired with its
+// request (RequestAck/RequestStart set), mirroring Listener's previous
+// dispatchMessage contract; an orphan response with no pending request is
+// dropped, same as before.
+func (a *StreamAssembler) Process(packet *TCPPacket, isIncoming bool) []*TCPMessage {
+	if len(packet.Data) == 0 {
+		return nil
+	}
+
+	key := keyForPacket(packet, isIncoming)
+
+	stream, ok := a.streams[key]
+	if !ok {
+		stream = newTCPStream()
+		a.streams[key] = stream
+		a.enforceInFlightLimit(key)
+	}
+	stream.lastActivity = time.Now()
+
+	dir := stream.req
+	if !isIncoming {
+		dir = stream.resp
+	}
+
+	var ready []*TCPMessage
+	for _, message := range a.addSegment(dir, packet, isIncoming) {
+		if paired := a.match(stream, message, isIncoming); paired != nil {
+			ready = append(ready, paired)
+		}
+	}
+
+	return ready
+}
+
+// maxPendingRequests bounds how many dispatched-but-unanswered requests a
+// single connection's pending FIFO may hold for response pairing. It isn't
+// exposed via ListenerOptions since pipelining this deep already means
+// something is wrong (a connection whose responses stopped arriving, or
+// one triggering the request/response mismatch bug this constant was
+// added alongside) rather than a capacity tradeoff worth tuning; it exists
+// purely so that case can't grow pending without bound.
+const maxPendingRequests = 4096
+
+// match applies the httpMatcher policy: requests are queued and dispatched
+// immediately, responses are paired with the oldest outstanding request on
+// the connection (supporting pipelining) or dropped if there is none.
+func (a *StreamAssembler) match(stream *tcpStream, message *TCPMessage, isIncoming bool) *TCPMessage {
+	if isIncoming {
+		stream.pending = append(stream.pending, message)
+
+		if len(stream.pending) > maxPendingRequests {
+			// The request itself is still returned to the caller for
+			// dispatch below; all we drop here is the bookkeeping
+			// that would have tagged a future response with it.
+			stream.pending = stream.pending[1:]
+			atomic.AddInt64(&a.messagesEvicted, 1)
+		}
+
+		return message
+	}
+
+	if len(stream.pending) == 0 {
+		return nil
+	}
+
+	req := stream.pending[0]
+	stream.pending = stream.pending[1:]
+	message.RequestAck = req.Ack
+	message.RequestStart = req.Start
+
+	return message
+}
+
+// enforceInFlightLimit evicts the least-recently-active stream other than
+// newKey (which was just created and has no activity yet to compare)
+// whenever tracking one more connection would exceed maxInFlightMessages.
+func (a *StreamAssembler) enforceInFlightLimit(newKey connKey) {
+	if a.maxInFlightMessages <= 0 || len(a.streams) <= a.maxInFlightMessages {
+		return
+	}
+
+	var oldestKey connKey
+	var oldestTime time.Time
+	found := false
+
+	for key, stream := range a.streams {
+		if key == newKey {
+			continue
+		}
+		if !found || stream.lastActivity.Before(oldestTime) {
+			oldestKey = key
+			oldestTime = stream.lastActivity
+			found = true
+		}
+	}
+
+	if found {
+		a.evictStream(oldestKey)
+	}
+}
+
+// evictStream drops a tracked connection outright, counting any
+// in-progress message it was holding as evicted rather than silently
+// losing it.
+func (a *StreamAssembler) evictStream(key connKey) {
+	stream, ok := a.streams[key]
+	if !ok {
+		return
+	}
+
+	if stream.req.message != nil {
+		atomic.AddInt64(&a.messagesEvicted, 1)
+	}
+	if stream.resp.message != nil {
+		atomic.AddInt64(&a.messagesEvicted, 1)
+	}
+
+	delete(a.streams, key)
+}
+
+// addSegment folds packet into dir's byte stream (dropping retransmits,
+// buffering out-of-order data, draining the reorder buffer once gaps
+// fill) and returns every message dir completes as a result -- usually
+// zero or one, but a reorder drain can unblock several pipelined messages
+// at once.
+func (a *StreamAssembler) addSegment(dir *directionBuffer, packet *TCPPacket, isIncoming bool) []*TCPMessage {
+	if !dir.started {
+		dir.started = true
+		dir.nextSeq = packet.Seq
+	}
+
+	var completed []*TCPMessage
+
+	seq := packet.Seq
+	end := seq + uint32(len(packet.Data))
+
+	switch {
+	case !seqLess(dir.nextSeq, end):
+		// Fully covered by what we've already seen: a pure retransmit.
+		return nil
+
+	case seqLess(seq, dir.nextSeq):
+		// Partial retransmit: trim the already-seen prefix and treat
+		// the new tail as an in-order continuation.
+		packet.Data = packet.Data[dir.nextSeq-seq:]
+		fallthrough
+
+	case seq == dir.nextSeq:
+		a.appendInOrder(dir, packet, isIncoming, &completed)
+
+		for {
+			next, ok := dir.reorder[dir.nextSeq]
+			if !ok {
+				break
+			}
+			delete(dir.reorder, dir.nextSeq)
+			dir.bufferedBytes -= len(next.Data)
+			a.appendInOrder(dir, next, isIncoming, &completed)
+		}
+
+	default:
+		// Out of order. Buffer it, bounded by reorderWindow; beyond
+		// that we give up on the gap and resync forward rather than
+		// stalling the stream forever on a lost segment.
+		if dir.bufferedBytes+len(packet.Data) > a.reorderWindow {
+			dir.reorder = make(map[uint32]*TCPPacket)
+			dir.bufferedBytes = 0
+			dir.nextSeq = seq
+			a.appendInOrder(dir, packet, isIncoming, &completed)
+		} else {
+			dir.reorder[seq] = packet
+			dir.bufferedBytes += len(packet.Data)
+		}
+	}
+
+	return completed
+}
+
+// appendInOrder feeds one in-order packet into dir's in-progress message,
+// advances nextSeq, and appends the message to *out if HTTP framing now
+// says it's complete (swallowing provisional 1xx responses rather than
+// surfacing them).
+func (a *StreamAssembler) appendInOrder(dir *directionBuffer, packet *TCPPacket, isIncoming bool, out *[]*TCPMessage) {
+	if dir.message == nil {
+		mID := packet.Addr.String() + strconv.Itoa(int(packet.DestPort)) + strconv.Itoa(int(packet.Ack))
+		dir.message = NewTCPMessage(mID, packet.Seq, packet.Ack, isIncoming)
+	}
+
+	dir.message.AddPacket(packet)
+	dir.raw = append(dir.raw, packet.Data...)
+	dir.nextSeq += uint32(len(packet.Data))
+	dir.packetCount++
+
+	overLimit := (a.maxPacketsPerMessage > 0 && dir.packetCount > a.maxPacketsPerMessage) ||
+		(a.maxMessageBytes > 0 && len(dir.raw) > a.maxMessageBytes)
+
+	if !overLimit && !dir.checkComplete(isIncoming) {
+		return
+	}
+
+	message := dir.message
+	provisional := dir.provisional
+	dir.reset()
+
+	if provisional {
+		return
+	}
+
+	if overLimit {
+		atomic.AddInt64(&a.messagesEvicted, 1)
+	}
+
+	*out = append(*out, message)
+}
+
+// checkComplete parses as much HTTP framing as it can out of dir.raw and
+// reports whether the in-progress message is now fully received.
+func (d *directionBuffer) checkComplete(isIncoming bool) bool {
+	if d.headerLen == 0 {
+		idx := bytes.Index(d.raw, bCRLFCRLF)
+		if idx == -1 {
+			return false
+		}
+
+		d.headerLen = idx + len(bCRLFCRLF)
+		headers := bytes.ToLower(d.raw[:d.headerLen])
+
+		d.chunked = bytes.Contains(headers, bTransferEncodingChunked)
+		if !d.chunked {
+			d.contentLength = parseContentLength(headers)
+		}
+		if !isIncoming {
+			d.provisional = isInformationalStatus(d.raw[:d.headerLen])
+		}
+	}
+
+	// 1xx responses never carry a body (RFC 7230 sec. 3.3), regardless
+	// of what Content-Length/Transfer-Encoding headers might claim.
+	if d.provisional {
+		return true
+	}
+
+	body := d.raw[d.headerLen:]
+
+	switch {
+	case d.chunked:
+		return chunkedBodyComplete(body)
+
+	case d.contentLength >= 0:
+		return len(body) >= d.contentLength
+
+	case isIncoming:
+		// No Content-Length/chunked on a request almost always means
+		// a bodyless request (GET, HEAD, ...): headers alone are the
+		// whole message.
+		return true
+
+	default:
+		// No Content-Length, not chunked, and not a request: the
+		// response is framed by the connection closing. isValidPacket
+		// still drops zero-payload packets (including the closing
+		// FIN) for throughput, so we can't observe that here yet --
+		// Listener's GC ticker finishes these via Expire instead, the
+		// same fallback the old alias-map code relied on.
+		return false
+	}
+}
+
+func parseContentLength(lowerHeaders []byte) int {
+	idx := bytes.Index(lowerHeaders, bContentLength)
+	if idx == -1 {
+		return -1
+	}
+
+	rest := lowerHeaders[idx+len(bContentLength):]
+	if end := bytes.IndexByte(rest, '\r'); end != -1 {
+		rest = rest[:end]
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(rest)))
+	if err != nil {
+		return -1
+	}
+
+	return n
+}
+
+// isInformationalStatus reports whether headers start with a 1xx status
+// line, e.g. "HTTP/1.1 100 Continue".
+func isInformationalStatus(headers []byte) bool {
+	idx := bytes.IndexByte(headers, ' ')
+	if idx == -1 || idx+1 >= len(headers) {
+		return false
+	}
+
+	return headers[idx+1] == '1'
+}
+
+// chunkedBodyComplete reports whether body contains a full chunked
+// transfer-encoding body, i.e. it can be walked chunk by chunk down to a
+// terminating zero-length chunk. Trailers are accepted but not validated.
+func chunkedBodyComplete(body []byte) bool {
+	for {
+		idx := bytes.Index(body, bCRLF)
+		if idx == -1 {
+			return false
+		}
+
+		size, err := strconv.ParseInt(string(bytes.TrimSpace(body[:idx])), 16, 64)
+		if err != nil {
+			return false
+		}
+
+		body = body[idx+len(bCRLF):]
+
+		if size == 0 {
+			return len(body) >= len(bCRLF)
+		}
+
+		need := int(size) + len(bCRLF)
+		if len(body) < need {
+			return false
+		}
+
+		body = body[need:]
+	}
+}
+
+// Expire flushes any in-progress message older than expire, same as
+// Listener's old GC ticker did by walking t.messages directly, and evicts
+// connections that have been completely idle for a while so streams and
+// pending don't grow without bound for connections we'll never hear from
+// again.
+func (a *StreamAssembler) Expire(expire time.Duration) []*TCPMessage {
+	now := time.Now()
+
+	var ready []*TCPMessage
+
+	for key, stream := range a.streams {
+		for _, d := range [...]struct {
+			dir        *directionBuffer
+			isIncoming bool
+		}{{stream.req, true}, {stream.resp, false}} {
+			dir := d.dir
+			if dir.message == nil || now.Sub(dir.message.Start) < expire {
+				continue
+			}
+
+			message := dir.message
+			provisional := dir.provisional
+			dir.reset()
+			atomic.AddInt64(&a.messagesExpired, 1)
+
+			if provisional {
+				continue
+			}
+
+			if paired := a.match(stream, message, d.isIncoming); paired != nil {
+				ready = append(ready, paired)
+			}
+		}
+
+		if stream.idle(now, expire) {
+			delete(a.streams, key)
+		}
+	}
+
+	return ready
+}
+
+// idle reports whether s has no in-progress or reorder-buffered data and
+// has been silent for a few expiry periods, making it safe to evict.
+// Requests still waiting in pending for a response don't keep s alive:
+// a response that hasn't arrived after that long is treated as lost.
+func (s *tcpStream) idle(now time.Time, expire time.Duration) bool {
+	return s.req.message == nil && s.resp.message == nil &&
+		len(s.req.reorder) == 0 && len(s.resp.reorder) == 0 &&
+		now.Sub(s.lastActivity) >= expire*4
+}
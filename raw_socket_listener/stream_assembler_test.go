@@ -0,0 +1,170 @@
+package rawSocket
+
+import (
+	"net"
+	"testing"
+)
+
+// newTestPacket builds a TCPPacket the way a capture backend would, for
+// feeding directly into StreamAssembler.Process without going through a
+// real socket or ParseTCPPacket.
+func newTestPacket(addr string, srcPort, destPort uint16, seq, ack uint32, data string) *TCPPacket {
+	return &TCPPacket{
+		Addr:     &net.IPAddr{IP: net.ParseIP(addr)},
+		SrcPort:  srcPort,
+		DestPort: destPort,
+		Seq:      seq,
+		Ack:      ack,
+		Data:     []byte(data),
+	}
+}
+
+func TestStreamAssembler_InOrder(t *testing.T) {
+	a := NewStreamAssembler()
+
+	req := newTestPacket("10.0.0.1", 53211, 80, 1000, 1, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	messages := a.Process(req, true)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message for a complete bodyless request, got %d", len(messages))
+	}
+}
+
+func TestStreamAssembler_OutOfOrder(t *testing.T) {
+	a := NewStreamAssembler()
+
+	// The first segment processed for a connection seeds nextSeq, so a
+	// genuine gap needs three segments: one to establish the baseline,
+	// one arriving ahead of it (buffered), and the missing middle one
+	// that fills the gap and drains the buffered tail.
+	full := "GET / HTTP/1.1\r\nHost: x\r\n\r\n"
+	head, gap, tail := full[:5], full[5:15], full[15:]
+
+	seg0 := newTestPacket("10.0.0.1", 53211, 80, 1000, 1, head)
+	if messages := a.Process(seg0, true); len(messages) != 0 {
+		t.Fatalf("expected the opening segment alone to be incomplete, got %d messages", len(messages))
+	}
+
+	seg2 := newTestPacket("10.0.0.1", 53211, 80, 1000+uint32(len(head)+len(gap)), 1, tail)
+	if messages := a.Process(seg2, true); len(messages) != 0 {
+		t.Fatalf("expected the out-of-order tail to be buffered, got %d messages", len(messages))
+	}
+
+	seg1 := newTestPacket("10.0.0.1", 53211, 80, 1000+uint32(len(head)), 1, gap)
+	messages := a.Process(seg1, true)
+	if len(messages) != 1 {
+		t.Fatalf("expected filling the gap to drain the buffered tail into 1 message, got %d", len(messages))
+	}
+}
+
+func TestStreamAssembler_Retransmit(t *testing.T) {
+	a := NewStreamAssembler()
+
+	req := newTestPacket("10.0.0.1", 53211, 80, 1000, 1, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	if messages := a.Process(req, true); len(messages) != 1 {
+		t.Fatalf("expected 1 message on first delivery, got %d", len(messages))
+	}
+
+	retransmit := newTestPacket("10.0.0.1", 53211, 80, 1000, 1, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if messages := a.Process(retransmit, true); len(messages) != 0 {
+		t.Fatalf("expected a byte-for-byte retransmit to produce no message, got %d", len(messages))
+	}
+}
+
+func TestStreamAssembler_RequestResponsePairing(t *testing.T) {
+	a := NewStreamAssembler()
+
+	req := newTestPacket("10.0.0.1", 53211, 80, 1000, 1, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	reqMessages := a.Process(req, true)
+	if len(reqMessages) != 1 {
+		t.Fatalf("expected 1 request message, got %d", len(reqMessages))
+	}
+	request := reqMessages[0]
+
+	resp := newTestPacket("10.0.0.1", 80, 53211, 2000, 1, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	respMessages := a.Process(resp, false)
+	if len(respMessages) != 1 {
+		t.Fatalf("expected 1 response message, got %d", len(respMessages))
+	}
+
+	response := respMessages[0]
+	if response.RequestAck != request.Ack {
+		t.Fatalf("response not paired with its request: RequestAck = %d, want %d", response.RequestAck, request.Ack)
+	}
+}
+
+func TestStreamAssembler_UnmatchedResponseIsDropped(t *testing.T) {
+	a := NewStreamAssembler()
+
+	resp := newTestPacket("10.0.0.1", 80, 53211, 2000, 1, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	if messages := a.Process(resp, false); len(messages) != 0 {
+		t.Fatalf("expected a response with no pending request to be dropped, got %d messages", len(messages))
+	}
+}
+
+// TestStreamAssembler_ResponseWithDifferentAddrIsNotPaired documents the
+// contract keyForPacket depends on: callers must resolve both directions of
+// a connection to the *same* client address before calling Process, since
+// connKey is derived straight from packet.Addr. CaptureModeRAW initially
+// violated this -- ip4:tcp/ip6:tcp ReadFrom reports the IP source address,
+// which is the client's for a request but this host's own for a response --
+// silently dropping every response; see resolveRAWPeerAddr in listener.go
+// (and listener_test.go) for the fix and its regression test. This test
+// pins down the StreamAssembler side of that contract: fed
+// genuinely different addresses, a response can't be paired at all, which
+// is exactly the failure mode the bug produced in practice.
+func TestStreamAssembler_ResponseWithDifferentAddrIsNotPaired(t *testing.T) {
+	a := NewStreamAssembler()
+
+	req := newTestPacket("10.0.0.1", 53211, 80, 1000, 1, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if messages := a.Process(req, true); len(messages) != 1 {
+		t.Fatalf("expected 1 request message, got %d", len(messages))
+	}
+
+	// Same client port, but a different address than the request used --
+	// e.g. this host's own address, as an un-normalized RAW response would
+	// report it -- so it resolves to a different connKey entirely.
+	resp := newTestPacket("127.0.0.1", 80, 53211, 2000, 1, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	if messages := a.Process(resp, false); len(messages) != 0 {
+		t.Fatalf("expected a response addressed from a different peer to go unpaired, got %d messages", len(messages))
+	}
+}
+
+func TestParseContentLength(t *testing.T) {
+	cases := []struct {
+		headers string
+		want    int
+	}{
+		{"host: x\r\ncontent-length: 42\r\n\r\n", 42},
+		{"host: x\r\ncontent-length:  7  \r\n\r\n", 7},
+		{"host: x\r\n\r\n", -1},
+		{"host: x\r\ncontent-length: not-a-number\r\n\r\n", -1},
+	}
+
+	for _, c := range cases {
+		if got := parseContentLength([]byte(c.headers)); got != c.want {
+			t.Errorf("parseContentLength(%q) = %d, want %d", c.headers, got, c.want)
+		}
+	}
+}
+
+func TestChunkedBodyComplete(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"complete single chunk", "5\r\nhello\r\n0\r\n\r\n", true},
+		{"complete multiple chunks", "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", true},
+		{"missing terminator", "5\r\nhello\r\n", false},
+		{"truncated mid-chunk", "5\r\nhel", false},
+		{"malformed size", "zzz\r\nhello\r\n", false},
+	}
+
+	for _, c := range cases {
+		if got := chunkedBodyComplete([]byte(c.body)); got != c.want {
+			t.Errorf("%s: chunkedBodyComplete(%q) = %v, want %v", c.name, c.body, got, c.want)
+		}
+	}
+}